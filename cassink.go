@@ -0,0 +1,248 @@
+// Content-addressable harvest store.  Records are written into a directory tree keyed by
+// the SHA-256 digest of their content, alongside a manifest mapping each URN to the digests
+// harvested for it and when.  Re-harvesting a provider that doesn't honour "from"/"until"
+// well then only costs a blob write when a record's content actually changed.
+//
+// CASHarvestSink is a HarvestSink (see sink.go), selected on the command line with
+// "--store cas:<dir>".
+
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+
+// casManifest maps URN -> digest -> the time that digest was last seen for that URN.
+type casManifest struct {
+    Entries     map[string]map[string]string   `json:"entries"`
+}
+
+// A harvest sink that writes records into a content-addressable directory tree instead of
+// one file per URN.
+type CASHarvestSink struct {
+    dir             string
+    manifestPath    string
+    manifest        casManifest
+}
+
+func NewCASHarvestSink(dir string) (*CASHarvestSink, error) {
+    if err := os.MkdirAll(dir, 0755) ; err != nil {
+        return nil, err
+    }
+
+    sink := &CASHarvestSink{
+        dir:            dir,
+        manifestPath:   filepath.Join(dir, "manifest.json"),
+        manifest:       casManifest{Entries: make(map[string]map[string]string)},
+    }
+
+    if err := sink.loadManifest() ; err != nil {
+        return nil, err
+    }
+    return sink, nil
+}
+
+func (sink *CASHarvestSink) loadManifest() error {
+    data, err := os.ReadFile(sink.manifestPath)
+    if os.IsNotExist(err) {
+        return nil
+    } else if err != nil {
+        return err
+    }
+
+    return json.Unmarshal(data, &sink.manifest)
+}
+
+func (sink *CASHarvestSink) saveManifest() error {
+    data, err := json.MarshalIndent(&sink.manifest, "", "  ")
+    if err != nil {
+        return err
+    }
+
+    return os.WriteFile(sink.manifestPath, data, 0644)
+}
+
+// Returns the path a blob with the given digest is stored under, splitting the digest into
+// two nesting levels so no single directory ends up with one entry per record.
+func (sink *CASHarvestSink) blobPath(digest string) string {
+    return filepath.Join(sink.dir, "blobs", digest[0:2], digest[2:4], digest + ".xml")
+}
+
+// Canonicalizes record content before hashing.  This is a simple whitespace normalization
+// rather than full XML C14N, but it's enough to stop re-harvests writing a new blob for a
+// record whose content is unchanged but whose surrounding whitespace differs.
+func canonicalizeCASContent(content string) string {
+    lines := strings.Split(content, "\n")
+    for i := range lines {
+        lines[i] = strings.TrimRight(lines[i], " \t\r")
+    }
+    return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+func digestOf(content string) string {
+    sum := sha256.Sum256([]byte(canonicalizeCASContent(content)))
+    return hex.EncodeToString(sum[:])
+}
+
+// Writes a record into the store.  If a blob with the same digest already exists for this
+// URN, only the manifest's timestamp is refreshed; the XML itself is not rewritten.
+func (sink *CASHarvestSink) WriteRecord(res *RecordResult) error {
+    urn := res.Identifier()
+    digest := digestOf(res.Content)
+    now := time.Now().UTC().Format(time.RFC3339)
+
+    if sink.manifest.Entries[urn] == nil {
+        sink.manifest.Entries[urn] = make(map[string]string)
+    }
+
+    if _, known := sink.manifest.Entries[urn][digest] ; !known {
+        path := sink.blobPath(digest)
+        if err := os.MkdirAll(filepath.Dir(path), 0755) ; err != nil {
+            return err
+        }
+        if err := os.WriteFile(path, []byte(res.Content), 0644) ; err != nil {
+            return err
+        }
+    }
+
+    sink.manifest.Entries[urn][digest] = now
+    return nil
+}
+
+// Flushes the manifest to disk.  Must be called once harvesting has finished.
+func (sink *CASHarvestSink) Close() error {
+    return sink.saveManifest()
+}
+
+// OpenBatch is a no-op: the CAS store doesn't have batches, every record is keyed by its own
+// digest regardless of dirId.
+func (sink *CASHarvestSink) OpenBatch(dirId int) error {
+    return nil
+}
+
+// CloseBatch is a no-op, for the same reason as OpenBatch.
+func (sink *CASHarvestSink) CloseBatch() (string, error) {
+    return "", nil
+}
+
+// SupportsResume is true: writes are keyed by content digest and the manifest is loaded
+// from disk on open, so reopening the store after a resume neither loses nor duplicates
+// anything already written.
+func (sink *CASHarvestSink) SupportsResume() bool {
+    return true
+}
+
+// ---------------------------------------------------------------------------------------------------
+// cas-verify command
+//      Walks the manifest, rechecks every referenced blob's digest, and reports any drift.
+
+type CASVerifyCommand struct {
+    Ctx         *Context
+    dir         *string
+}
+
+func (c *CASVerifyCommand) Flags(fs *flag.FlagSet) *flag.FlagSet {
+    c.dir = fs.String("d", "", "Path to the CAS store directory")
+    return fs
+}
+
+func (c *CASVerifyCommand) Run(args []string) {
+    if *c.dir == "" {
+        log.Fatal("cas-verify: the store directory is required (-d)")
+    }
+
+    sink, err := NewCASHarvestSink(*c.dir)
+    if err != nil {
+        log.Fatal("cas-verify: cannot open CAS store: ", err)
+    }
+
+    checked, drifted := 0, 0
+    for urn, digests := range sink.manifest.Entries {
+        for digest := range digests {
+            checked++
+
+            data, err := os.ReadFile(sink.blobPath(digest))
+            if err != nil {
+                fmt.Printf("%s %s: missing blob: %s\n", urn, digest, err)
+                drifted++
+                continue
+            }
+
+            if actual := digestOf(string(data)) ; actual != digest {
+                fmt.Printf("%s %s: digest mismatch, blob now hashes to %s\n", urn, digest, actual)
+                drifted++
+            }
+        }
+    }
+
+    log.Printf("cas-verify: %d digests checked, %d drifted", checked, drifted)
+}
+
+// ---------------------------------------------------------------------------------------------------
+// cas-gc command
+//      Removes blobs that are no longer referenced by any manifest entry.
+
+type CASGCCommand struct {
+    Ctx         *Context
+    dir         *string
+    dryRun      *bool
+}
+
+func (c *CASGCCommand) Flags(fs *flag.FlagSet) *flag.FlagSet {
+    c.dir = fs.String("d", "", "Path to the CAS store directory")
+    c.dryRun = fs.Bool("n", false, "Dry run.  Only report blobs that would be removed.")
+    return fs
+}
+
+func (c *CASGCCommand) Run(args []string) {
+    if *c.dir == "" {
+        log.Fatal("cas-gc: the store directory is required (-d)")
+    }
+
+    sink, err := NewCASHarvestSink(*c.dir)
+    if err != nil {
+        log.Fatal("cas-gc: cannot open CAS store: ", err)
+    }
+
+    referenced := make(map[string]bool)
+    for _, digests := range sink.manifest.Entries {
+        for digest := range digests {
+            referenced[digest] = true
+        }
+    }
+
+    blobsDir := filepath.Join(sink.dir, "blobs")
+    removed := 0
+
+    filepath.Walk(blobsDir, func(path string, info os.FileInfo, err error) error {
+        if (err != nil) || info.IsDir() {
+            return nil
+        }
+
+        digest := strings.TrimSuffix(filepath.Base(path), ".xml")
+        if referenced[digest] {
+            return nil
+        }
+
+        removed++
+        if *c.dryRun {
+            fmt.Printf("would remove %s\n", path)
+        } else {
+            fmt.Printf("removing %s\n", path)
+            os.Remove(path)
+        }
+        return nil
+    })
+
+    log.Printf("cas-gc: %d unreferenced blobs %s", removed, map[bool]string{true: "found", false: "removed"}[*c.dryRun])
+}
@@ -0,0 +1,157 @@
+// Harvest checkpointing.  A HarvestJournal is a small JSON file recording enough state about
+// an in-progress harvest -- the run's directory prefix, how far through it we'd got, and the
+// last resumption token a paging-aware harvester reported -- that an interrupted harvest can
+// pick back up close to where it left off instead of starting from record one.  This is the
+// same idea as the parent-snapshot pointer a backup tool keeps: it makes reruns cheap.
+
+package main
+
+import (
+    "encoding/json"
+    "os"
+    "sync"
+    "time"
+)
+
+
+// Flush the journal as soon as either of these limits is reached, whichever comes first.
+const (
+    checkpointEveryRecords  = 500
+    checkpointEveryDuration = 30 * time.Second
+)
+
+// journalState is the on-disk format of a checkpoint file.
+type journalState struct {
+    DirPrefix           string      `json:"dir_prefix"`
+    DirId               int         `json:"dir_id"`
+    RecordCount         int         `json:"record_count"`
+    LastIdentifier      string      `json:"last_identifier"`
+    ResumptionToken     string      `json:"resumption_token"`
+    UpdatedAt           time.Time   `json:"updated_at"`
+}
+
+// A HarvestJournal persists journalState to a file, flushing periodically rather than on
+// every record so a multi-hour harvest isn't dominated by journal writes.  mu guards every
+// field below it: the harvest loop updates state via Record/SetResumptionToken while the
+// SIGINT handler in catchInterrupt calls Flush concurrently from its own goroutine.
+type HarvestJournal struct {
+    path            string
+
+    mu              sync.Mutex
+    state           journalState
+    sinceFlush      int
+    lastFlushAt     time.Time
+}
+
+// OpenHarvestJournal loads the checkpoint at path, if one exists.  existed reports whether a
+// prior run's checkpoint was found, so the caller knows whether it's resuming or starting
+// fresh.
+func OpenHarvestJournal(path string) (journal *HarvestJournal, existed bool, err error) {
+    journal = &HarvestJournal{path: path, lastFlushAt: time.Now()}
+
+    data, err := os.ReadFile(path)
+    if os.IsNotExist(err) {
+        return journal, false, nil
+    } else if err != nil {
+        return nil, false, err
+    }
+
+    if err := json.Unmarshal(data, &journal.state) ; err != nil {
+        return nil, false, err
+    }
+    return journal, true, nil
+}
+
+// DirPrefix, DirId, RecordCount, LastIdentifier and ResumptionToken expose the checkpoint's
+// last-flushed state, for a HarvestCommand to resume from.
+func (j *HarvestJournal) DirPrefix() string {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    return j.state.DirPrefix
+}
+
+func (j *HarvestJournal) DirId() int {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    return j.state.DirId
+}
+
+func (j *HarvestJournal) RecordCount() int {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    return j.state.RecordCount
+}
+
+func (j *HarvestJournal) LastIdentifier() string {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    return j.state.LastIdentifier
+}
+
+func (j *HarvestJournal) ResumptionToken() string {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    return j.state.ResumptionToken
+}
+
+// SetDirPrefix fixes the directory prefix for a fresh run, so it's carried into every
+// subsequent flush.
+func (j *HarvestJournal) SetDirPrefix(prefix string) {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    j.state.DirPrefix = prefix
+}
+
+// SetResumptionToken records the paging token of the most recent page fetched, for
+// harvesters that support resuming a list from a specific token.  Harvesters that don't
+// simply never call this, and the field stays empty.
+func (j *HarvestJournal) SetResumptionToken(token string) {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    j.state.ResumptionToken = token
+}
+
+// Record updates the in-memory checkpoint after a record has been written, flushing to disk
+// if enough records have gone by or enough time has passed since the last flush.
+func (j *HarvestJournal) Record(dirId int, recordCount int, identifier string) error {
+    j.mu.Lock()
+    j.state.DirId = dirId
+    j.state.RecordCount = recordCount
+    j.state.LastIdentifier = identifier
+    j.sinceFlush++
+
+    due := (j.sinceFlush >= checkpointEveryRecords) || (time.Since(j.lastFlushAt) >= checkpointEveryDuration)
+    j.mu.Unlock()
+
+    if !due {
+        return nil
+    }
+    return j.Flush()
+}
+
+// Flush writes the checkpoint to disk immediately, regardless of how long it's been since
+// the last flush.
+func (j *HarvestJournal) Flush() error {
+    j.mu.Lock()
+    j.state.UpdatedAt = time.Now().UTC()
+    j.sinceFlush = 0
+    j.lastFlushAt = time.Now()
+    data, err := json.MarshalIndent(&j.state, "", "  ")
+    j.mu.Unlock()
+
+    if err != nil {
+        return err
+    }
+    return os.WriteFile(j.path, data, 0644)
+}
+
+// Remove deletes the checkpoint file.  Called once a harvest completes cleanly, so the next
+// invocation with the same --resume file starts a fresh run rather than replaying a
+// finished one.
+func (j *HarvestJournal) Remove() error {
+    err := os.Remove(j.path)
+    if os.IsNotExist(err) {
+        return nil
+    }
+    return err
+}
@@ -4,11 +4,11 @@ package main
 import (
     "fmt"
     "os"
-    "os/exec"
-    "path"
+    "os/signal"
     "flag"
+    "sync"
     "time"
-    "path/filepath"
+    "syscall"
     "log"
 )
 
@@ -28,6 +28,21 @@ type HarvestCommand struct {
     fromFile            *string
     filenameFilter      *string
     filenameFilterAst   RSExprAst
+    includeFile         *string
+    excludeFile         *string
+    excludeURN          *string
+    excludeExpr         *string
+    jsonOutput          *bool
+    store               *string
+    resumeFile          *string
+    selector            *Selector
+    observer            HarvesterObserver
+    sinkMu              sync.Mutex
+    sink                HarvestSink
+    journal             *HarvestJournal
+    resumeIdentifier    string
+    resumeToken         string
+    skippedToResume     bool
     firstResult         *int
     maxResults          *int
     maxDirSize          *int
@@ -35,10 +50,20 @@ type HarvestCommand struct {
     dirPrefix           string
     recordCount         int
     lastDirId           int
+    startedAt           time.Time
+    lastProgressAt      time.Time
 }
 
-// Get list identifier arguments
+// Get list identifier arguments.  If we're resuming a checkpointed harvest that got as far
+// as recording a resumptionToken, pass that along instead of Set/From/Until: per the OAI-PMH
+// spec a resumptionToken already encodes the original request, and a provider given both a
+// token and fresh selection criteria is only required to honour the token, so there's no
+// point re-sending criteria it'll ignore.
 func (lc *HarvestCommand) genListIdentifierArgsFromCommandLine() ListIdentifierArgs {
+    if lc.resumeToken != "" {
+        return ListIdentifierArgs{ResumptionToken: lc.resumeToken}
+    }
+
     var set string
 
     set = *(lc.setName)
@@ -62,105 +87,167 @@ func (lc *HarvestCommand) dirName(dirId int) string {
     return fmt.Sprintf("%s/%02d", lc.dirPrefix, dirId)
 }
 
-// Saves the record
-func (lc *HarvestCommand) saveRecordToDir(dirId int, res *RecordResult) {
-    dir := lc.dirName(dirId)
+// Close the current batch before opening a new one.
+func (lc *HarvestCommand) closeDir(dirId int) {
+    // Do nothing if this is a dry run
+    if *(lc.dryRun) {
+        return
+    }
 
-    // The filename to use.  If there's a filter, execute it and use the returned string
-    // as the filename.  Otherwise, simply use the records URN
-    var resId = res.Identifier()
-    var filename string = resId
+    lc.sinkMu.Lock()
+    archive, err := lc.sink.CloseBatch()
+    lc.sinkMu.Unlock()
 
-    if lc.filenameFilterAst != nil {
-        res, err := lc.filenameFilterAst.Evaluate(res)
-        if (err == nil) && (res != nil) && (res.Bool()) {
-            filename = res.String()
-        } else if (err != nil) {
-            log.Printf("%s: error in filename filter, using the URN: %s", resId, err.Error())
-        } else {
-            log.Printf("%s: warn: filename filter returned false, using the URN", resId)
-        }
+    if err != nil {
+        lc.observer.OnError(err)
+        return
     }
-
-    // Escape filenames to avoid invalid characters such as '/' causing
-    // potential file naming problems.
-    fileBaseName := EscapeIdForFilename(filename)
-    if fileBaseName == "" {
-        log.Println("warn: using file basename '__empty__' for record with an empty identifier")
-        fileBaseName = "__empty__"
+    if archive != "" {
+        lc.observer.OnDirCompressed(lc.dirName(dirId), archive)
     }
+}
 
-    outFile := filepath.Join(dir, fileBaseName + ".xml")
-
-    os.MkdirAll(dir, 0755)
+func (lc *HarvestCommand) saveRecord(res *RecordResult) {
+    // If we're resuming a checkpointed harvest without a saved resumption token -- an older
+    // checkpoint, or a harvester that never reports one -- the list restarts from the top,
+    // so silently replay records until we reach the one the checkpoint says was last
+    // written, then pick back up from the next one.  When a token was saved,
+    // genListIdentifierArgsFromCommandLine already resumed the list past that point, so
+    // skippedToResume starts true and this branch never runs.
+    if (lc.journal != nil) && !lc.skippedToResume {
+        if res.Identifier() == lc.resumeIdentifier {
+            lc.skippedToResume = true
+        }
+        return
+    }
 
-    file, err := os.Create(outFile)
-    if err != nil {
-        panic(err)
+    if (lc.selector != nil) && !lc.selector.Allows(res) {
+        lc.selector.Skipped++
+        return
     }
-    defer file.Close()
 
-    file.WriteString(res.Content)
-}
+    lc.recordCount++
 
-// Close the current directory before creating and writing to a new one
-func (lc *HarvestCommand) closeDir(dirId int) {
-    // Do nothing if this is a dry run
-    if *(lc.dryRun) {
-        return
+    dirId := (lc.recordCount / *(lc.maxDirSize)) + 1
+    if (dirId != lc.lastDirId) {
+        lc.closeDir(lc.lastDirId)
+        lc.lastDirId = dirId
+        lc.sinkMu.Lock()
+        err := lc.sink.OpenBatch(dirId)
+        lc.sinkMu.Unlock()
+        if err != nil {
+            lc.observer.OnError(err)
+        }
+        lc.observer.OnDirRotated(dirId, lc.dirName(dirId))
     }
 
-    dir := lc.dirName(dirId)
-    if *(lc.compressDirs) {
-        base := path.Base(dir)
-        parent := path.Dir(dir)
+    lc.observer.OnRecord(lc.recordCount, res)
+    lc.maybeEmitProgress()
 
-        if (lc.Ctx.LogLevel >= TraceLogLevel) {
-            log.Printf("Compressing %s -> %s", base, dir + ".zip")
+    if (! *(lc.dryRun)) {
+        lc.sinkMu.Lock()
+        err := lc.sink.WriteRecord(res)
+        lc.sinkMu.Unlock()
+        if err != nil {
+            lc.observer.OnError(err)
         }
+    }
 
-        cmd := exec.Command("zip", "-m", "-r", base + ".zip", base)
-        cmd.Dir = parent
-        err := cmd.Start()
-        if (err != nil) {
-            fmt.Fprintf(os.Stderr, "Cannot compress '%s'\n", dir)
+    if lc.journal != nil {
+        if err := lc.journal.Record(dirId, lc.recordCount, res.Identifier()) ; err != nil {
+            lc.observer.OnError(err)
         }
     }
 }
 
-func (lc *HarvestCommand) saveRecord(res *RecordResult) {
-    lc.recordCount++
-    dirId := (lc.recordCount / *(lc.maxDirSize)) + 1
-    if (dirId != lc.lastDirId) {
-        lc.closeDir(lc.lastDirId)
-        lc.lastDirId = dirId
+// harvestGuard is the -L pre-fetch guard: it runs against a record's header, before
+// ListAndGetRecordHarvester spends a GetRecord fetching its content, so a selector-excluded
+// identifier never pays for the fetch at all.  It still checks LiveRecordsHeaderPredicate
+// first, so a deleted/withdrawn header is dropped the same way it always was.
+func (lc *HarvestCommand) harvestGuard(h *Header) bool {
+    if !LiveRecordsHeaderPredicate(h) {
+        return false
     }
 
-    if (lc.Ctx.LogLevel >= DebugLogLevel) {
-        log.Printf("%8d  %s\n", lc.recordCount, res.Identifier())
+    if (lc.selector != nil) && !lc.selector.AllowsIdentifier(h.Identifier()) {
+        lc.selector.Skipped++
+        return false
     }
-    if ((lc.recordCount % 1000) == 0) {
-        log.Printf("Harvested %d records\n", lc.recordCount)
+
+    return true
+}
+
+// Emits a progress event, at most once a second, with the current throughput and an ETA
+// based on the configured maximum number of records.
+func (lc *HarvestCommand) maybeEmitProgress() {
+    now := time.Now()
+    if (now.Sub(lc.lastProgressAt) < time.Second) {
+        return
     }
+    lc.lastProgressAt = now
 
-    if (! *(lc.dryRun)) {
-        lc.saveRecordToDir(dirId, res)
+    var rate float64
+    var eta time.Duration
+
+    if elapsed := now.Sub(lc.startedAt).Seconds() ; elapsed > 0 {
+        rate = float64(lc.recordCount) / elapsed
+    }
+    if remaining := *(lc.maxResults) - lc.recordCount ; (rate > 0) && (remaining > 0) {
+        eta = time.Duration(float64(remaining)/rate*float64(time.Second))
     }
+
+    lc.observer.OnProgress(lc.recordCount, rate, eta)
 }
 
 
-// Contract with the HarvesterObserver
+// Contract with the Harvester
 
 func (lc *HarvestCommand) OnRecord(rr *RecordResult) {
     lc.saveRecord(rr)
 }
 
 func (lc *HarvestCommand) OnError(err error) {
-    log.Printf("ERROR: %s\n", err)
+    lc.observer.OnError(err)
+}
+
+// OnResumptionToken is called by harvesters that page through a list using an OAI
+// resumptionToken, each time a new page is fetched.  Harvesters that don't support paging
+// simply never call it, and the checkpoint's resumption token stays empty.
+func (lc *HarvestCommand) OnResumptionToken(token string) {
+    if lc.journal != nil {
+        lc.journal.SetResumptionToken(token)
+    }
 }
 
 func (lc *HarvestCommand) OnCompleted(harvested int, skipped int, errors int) {
-    log.Printf("Finished: %d records harvested, %d records skipped, %d errors", harvested, skipped, errors)
+    filtered := 0
+    if lc.selector != nil {
+        filtered = lc.selector.Skipped
+    }
+    lc.observer.OnCompleted(harvested, skipped, filtered, errors)
+
+    if lc.journal != nil {
+        switch {
+        // A resume that never reached the checkpointed record wrote nothing new -- the
+        // resumeIdentifier was never matched in the replayed list, perhaps because it was
+        // since withdrawn upstream, or the run's workers handed records to saveRecord out
+        // of order.  Keep the checkpoint so a rerun still knows where to resume from,
+        // rather than reporting a clean completion that harvested nothing.
+        case !lc.skippedToResume:
+            log.Println("warn: resume never reached the checkpointed record; keeping the checkpoint rather than reporting a clean completion")
+            if err := lc.journal.Flush() ; err != nil {
+                lc.observer.OnError(err)
+            }
+        case errors == 0:
+            if err := lc.journal.Remove() ; err != nil {
+                lc.observer.OnError(err)
+            }
+        default:
+            if err := lc.journal.Flush() ; err != nil {
+                lc.observer.OnError(err)
+            }
+        }
+    }
 }
 
 // Harvest the records using a specific harvester
@@ -184,14 +271,16 @@ func (lc *HarvestCommand) harvest() {
             Guard:          LiveRecordsPredicate,
         }
     } else if *(lc.listAndGet) {
-        // Get the list and pass it to the getters in parallel
+        // Get the list and pass it to the getters in parallel.  HarvestGuard runs against
+        // the header, before a record's content is fetched, so a selector-excluded
+        // identifier is dropped for the cost of a list entry rather than a full GetRecord.
         harvester = &ListAndGetRecordHarvester{
             Session:        lc.Ctx.Session,
             ListArgs:       args,
             FirstResult:    *(lc.firstResult),
             MaxResults:     *(lc.maxResults),
             Workers:        *(lc.downloadWorkers),
-            HarvestGuard:   LiveRecordsHeaderPredicate,
+            HarvestGuard:   lc.harvestGuard,
             Guard:          LiveRecordsPredicate,
         }
     } else {
@@ -222,6 +311,13 @@ func (lc *HarvestCommand) Flags(fs *flag.FlagSet) *flag.FlagSet {
 
     // Advanded options
     lc.filenameFilter = fs.String("N", "", "Use rs-expression for filename")
+    lc.includeFile = fs.String("include-file", "", "Only keep records whose identifier matches a pattern in this file")
+    lc.excludeFile = fs.String("exclude-file", "", "Skip records whose identifier matches a pattern in this file")
+    lc.excludeURN = fs.String("exclude-urn", "", "Skip records whose identifier matches this comma-separated list of glob patterns")
+    lc.excludeExpr = fs.String("exclude-expr", "", "Skip records for which this rs-expression evaluates to true")
+    lc.jsonOutput = fs.Bool("json", false, "Emit one JSON event per line to stdout instead of logging")
+    lc.store = fs.String("store", "", "Output sink: \"\" for the per-URN directory layout, cas:<dir>, tar:<dir>, targz:<dir>, zip:<file> or s3:<bucket>/<prefix>")
+    lc.resumeFile = fs.String("resume", "", "Checkpoint file.  If it exists from an earlier, interrupted run, resume from it; otherwise create it")
 
     return fs
 }
@@ -236,9 +332,105 @@ func (lc *HarvestCommand) Run(args []string) {
         }
     }
 
+    // Build the record selector, if any of its sources were given
+    selector, err := NewSelector(*lc.includeFile, *lc.excludeFile, *lc.excludeURN, *lc.excludeExpr)
+    if err != nil {
+        log.Fatal("Error setting up record selection: ", err)
+    }
+    lc.selector = selector
+
+    if *lc.jsonOutput {
+        lc.observer = NewJSONObserver()
+    } else {
+        lc.observer = &LogObserver{LogLevel: lc.Ctx.LogLevel}
+    }
+
     lc.lastDirId = 1
-    lc.dirPrefix = time.Now().Format("20060102T150405")
+    lc.startedAt = time.Now()
+    lc.dirPrefix = lc.startedAt.Format("20060102T150405")
+
+    // Open the checkpoint, if one was requested.  If it already exists, we're resuming an
+    // earlier, interrupted run: reopen its directory tree and replay records up to the one
+    // it last saw before saving anything new.
+    if *lc.resumeFile != "" {
+        journal, existed, err := OpenHarvestJournal(*lc.resumeFile)
+        if err != nil {
+            log.Fatal("Error opening checkpoint: ", err)
+        }
+        lc.journal = journal
+
+        if existed {
+            lc.dirPrefix = journal.DirPrefix()
+            lc.lastDirId = journal.DirId()
+            lc.recordCount = journal.RecordCount()
+            lc.resumeIdentifier = journal.LastIdentifier()
+            lc.resumeToken = journal.ResumptionToken()
+            if lc.resumeToken != "" {
+                log.Printf("Resuming checkpointed harvest at record %d using the saved resumption token\n", lc.recordCount)
+            } else {
+                log.Printf("Resuming checkpointed harvest at record %d (last identifier %s)\n", lc.recordCount, lc.resumeIdentifier)
+            }
+        } else {
+            journal.SetDirPrefix(lc.dirPrefix)
+        }
+        // With a resumption token, the list itself picks back up past the checkpoint, so
+        // there's nothing to replay.  Without one, fall back to replaying client-side until
+        // resumeIdentifier turns up again.
+        lc.skippedToResume = (lc.resumeIdentifier == "") || (lc.resumeToken != "")
+    }
+
+    // Set up the output sink now that dirPrefix has its final value -- the time-based
+    // default, or whatever a resumed checkpoint recorded.
+    sink, err := NewSink(*lc.store, lc.dirPrefix, lc.filenameFilterAst, *lc.compressDirs)
+    if err != nil {
+        log.Fatal("Error setting up output sink: ", err)
+    }
+    if (lc.journal != nil) && (lc.recordCount > 0) && !sink.SupportsResume() {
+        log.Fatalf("--resume is not supported with --store %s: reopening it would truncate the in-progress batch; use the per-directory layout, cas: or s3: instead", *lc.store)
+    }
+    lc.sink = sink
+
+    if err := lc.sink.OpenBatch(lc.lastDirId) ; err != nil {
+        log.Fatal("Error opening output sink: ", err)
+    }
+
+    // Only armed once lc.sink exists, so a SIGINT during setup can't reach a nil sink.
+    if lc.journal != nil {
+        lc.catchInterrupt()
+    }
+
     lc.harvest()
     lc.closeDir(lc.lastDirId)
 
+    if err := lc.sink.Close() ; err != nil {
+        log.Fatal("Error closing output sink: ", err)
+    }
+}
+
+// catchInterrupt flushes the checkpoint, closes the output sink and exits non-zero on
+// SIGINT, so an orchestrating process can tell an interrupted harvest apart from one that
+// completed, a rerun against the same --resume file picks up where this one left off, and
+// an archive-backed sink (tar, targz, zip) is finalised -- the tar trailer or the zip
+// central directory written -- instead of abandoned mid-write.
+func (lc *HarvestCommand) catchInterrupt() {
+    sigCh := make(chan os.Signal, 1)
+    signal.Notify(sigCh, syscall.SIGINT)
+
+    go func() {
+        <-sigCh
+        log.Println("Interrupted, flushing checkpoint...")
+        if err := lc.journal.Flush() ; err != nil {
+            log.Println("Error flushing checkpoint: ", err)
+        }
+
+        lc.closeDir(lc.lastDirId)
+
+        lc.sinkMu.Lock()
+        closeErr := lc.sink.Close()
+        lc.sinkMu.Unlock()
+        if closeErr != nil {
+            log.Println("Error closing output sink: ", closeErr)
+        }
+        os.Exit(1)
+    }()
 }
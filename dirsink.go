@@ -0,0 +1,148 @@
+// The default harvest sink: one file per record, in directories of -D records apiece.  This is the original HarvestCommand behaviour, pulled out so it can sit
+// alongside the other HarvestSink implementations (see sink.go).
+
+package main
+
+import (
+    "archive/zip"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "path/filepath"
+)
+
+
+// DirSink writes each record to dirPrefix/<dirId>/<filename>.xml.  If compress is set, a
+// batch is zipped up into dirPrefix/<dirId>.zip and the original directory removed as soon
+// as it's closed, matching the old "-C" behaviour -- except done with archive/zip rather
+// than shelling out to a "zip" binary, so it works without one on the PATH.
+type DirSink struct {
+    dirPrefix           string
+    filenameFilterAst   RSExprAst
+    compress            bool
+
+    curDirId            int
+    curDir              string
+}
+
+func NewDirSink(dirPrefix string, filenameFilterAst RSExprAst, compress bool) *DirSink {
+    return &DirSink{dirPrefix: dirPrefix, filenameFilterAst: filenameFilterAst, compress: compress}
+}
+
+func (sink *DirSink) dirName(dirId int) string {
+    return fmt.Sprintf("%s/%02d", sink.dirPrefix, dirId)
+}
+
+func (sink *DirSink) OpenBatch(dirId int) error {
+    sink.curDirId = dirId
+    sink.curDir = sink.dirName(dirId)
+    return os.MkdirAll(sink.curDir, 0755)
+}
+
+func (sink *DirSink) WriteRecord(res *RecordResult) error {
+    // The filename to use.  If there's a filter, execute it and use the returned string as
+    // the filename.  Otherwise, simply use the record's URN.
+    var resId = res.Identifier()
+    var filename string = resId
+
+    if sink.filenameFilterAst != nil {
+        result, err := sink.filenameFilterAst.Evaluate(res)
+        if (err == nil) && (result != nil) && (result.Bool()) {
+            filename = result.String()
+        } else if (err != nil) {
+            log.Printf("%s: error in filename filter, using the URN: %s", resId, err.Error())
+        } else {
+            log.Printf("%s: warn: filename filter returned false, using the URN", resId)
+        }
+    }
+
+    // Escape filenames to avoid invalid characters such as '/' causing potential file
+    // naming problems.
+    fileBaseName := EscapeIdForFilename(filename)
+    if fileBaseName == "" {
+        log.Println("warn: using file basename '__empty__' for record with an empty identifier")
+        fileBaseName = "__empty__"
+    }
+
+    outFile := filepath.Join(sink.curDir, fileBaseName + ".xml")
+
+    file, err := os.Create(outFile)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    _, err = file.WriteString(res.Content)
+    return err
+}
+
+func (sink *DirSink) CloseBatch() (string, error) {
+    if !sink.compress {
+        return "", nil
+    }
+
+    archive := sink.curDir + ".zip"
+    if err := zipDirectory(sink.curDir, archive) ; err != nil {
+        return "", err
+    }
+    if err := os.RemoveAll(sink.curDir) ; err != nil {
+        return "", err
+    }
+
+    return archive, nil
+}
+
+func (sink *DirSink) Close() error {
+    return nil
+}
+
+// SupportsResume is true: OpenBatch only ever mkdirs, so reopening an in-progress batch
+// after a resume picks up the existing directory rather than destroying it.
+func (sink *DirSink) SupportsResume() bool {
+    return true
+}
+
+// zipDirectory writes every file directly under dir into a new zip archive at archivePath.
+func zipDirectory(dir string, archivePath string) error {
+    entries, err := os.ReadDir(dir)
+    if err != nil {
+        return err
+    }
+
+    out, err := os.Create(archivePath)
+    if err != nil {
+        return err
+    }
+    defer out.Close()
+
+    zw := zip.NewWriter(out)
+    for _, entry := range entries {
+        if entry.IsDir() {
+            continue
+        }
+
+        if err := addFileToZip(zw, filepath.Join(dir, entry.Name()), entry.Name()) ; err != nil {
+            zw.Close()
+            return err
+        }
+    }
+
+    return zw.Close()
+}
+
+func addFileToZip(zw *zip.Writer, path string, name string) error {
+    in, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer in.Close()
+
+    w, err := zw.Create(name)
+    if err != nil {
+        return err
+    }
+
+    _, err = io.Copy(w, in)
+    return err
+}
@@ -0,0 +1,162 @@
+// Harvester observers.  A HarvesterObserver is notified of every significant event during a
+// harvest; HarvestCommand drives one internally so the same event stream can be rendered
+// either as log lines (LogObserver) or as newline-delimited JSON (JSONObserver).
+
+package main
+
+import (
+    "encoding/json"
+    "log"
+    "os"
+    "time"
+)
+
+
+type HarvesterObserver interface {
+
+    // A record was harvested.  count is the running total of harvested records.
+    OnRecord(count int, rr *RecordResult)
+
+    // An error occurred while harvesting.
+    OnError(err error)
+
+    // The output directory was rotated because it reached its maximum size.
+    OnDirRotated(dirId int, dir string)
+
+    // An output directory was compressed into archive.  This fires once the sink's
+    // CloseBatch has finished writing the archive.
+    OnDirCompressed(dir string, archive string)
+
+    // A progress update, emitted at most once a second.
+    OnProgress(count int, rate float64, eta time.Duration)
+
+    // The harvest has finished.
+    OnCompleted(harvested int, skipped int, filtered int, errors int)
+}
+
+// ------------------------------------------------------------------------------
+// Log-based observer.  This is the original HarvestCommand behaviour, logging each event
+// as a line through the standard "log" package.
+
+type LogObserver struct {
+    LogLevel    int
+}
+
+func (lo *LogObserver) OnRecord(count int, rr *RecordResult) {
+    if (lo.LogLevel >= DebugLogLevel) {
+        log.Printf("%8d  %s\n", count, rr.Identifier())
+    }
+    if ((count % 1000) == 0) {
+        log.Printf("Harvested %d records\n", count)
+    }
+}
+
+func (lo *LogObserver) OnError(err error) {
+    log.Printf("ERROR: %s\n", err)
+}
+
+func (lo *LogObserver) OnDirRotated(dirId int, dir string) {
+    if (lo.LogLevel >= DebugLogLevel) {
+        log.Printf("Rotated into directory %s\n", dir)
+    }
+}
+
+func (lo *LogObserver) OnDirCompressed(dir string, archive string) {
+    if (lo.LogLevel >= TraceLogLevel) {
+        log.Printf("Compressing %s -> %s", dir, archive)
+    }
+}
+
+func (lo *LogObserver) OnProgress(count int, rate float64, eta time.Duration) {
+    // The "Harvested %d records" line from OnRecord already serves as a progress
+    // indicator in log mode.
+}
+
+func (lo *LogObserver) OnCompleted(harvested int, skipped int, filtered int, errors int) {
+    if (filtered > 0) {
+        log.Printf("Finished: %d records harvested, %d records skipped, %d filtered by selection rules, %d errors",
+            harvested, skipped, filtered, errors)
+    } else {
+        log.Printf("Finished: %d records harvested, %d records skipped, %d errors", harvested, skipped, errors)
+    }
+}
+
+// ------------------------------------------------------------------------------
+// JSON observer.  Emits one JSON object per line to stdout so the harvest can be driven by
+// a wrapper process instead of being scraped out of free-form log lines.
+
+type jsonRecordEvent struct {
+    Event       string  `json:"event"`
+    Count       int     `json:"count"`
+    Identifier  string  `json:"identifier"`
+}
+
+type jsonErrorEvent struct {
+    Event       string  `json:"event"`
+    Message     string  `json:"message"`
+}
+
+type jsonDirRotatedEvent struct {
+    Event       string  `json:"event"`
+    DirId       int     `json:"dir_id"`
+    Dir         string  `json:"dir"`
+}
+
+type jsonDirCompressedEvent struct {
+    Event       string  `json:"event"`
+    Dir         string  `json:"dir"`
+    Archive     string  `json:"archive"`
+}
+
+type jsonProgressEvent struct {
+    Event       string  `json:"event"`
+    Count       int     `json:"count"`
+    RatePerSec  float64 `json:"rate_per_sec"`
+    EtaSeconds  float64 `json:"eta_seconds"`
+}
+
+type jsonSummaryEvent struct {
+    Event       string  `json:"event"`
+    Harvested   int     `json:"harvested"`
+    Skipped     int     `json:"skipped"`
+    Filtered    int     `json:"filtered"`
+    Errors      int     `json:"errors"`
+}
+
+type JSONObserver struct {
+    enc     *json.Encoder
+}
+
+func NewJSONObserver() *JSONObserver {
+    return &JSONObserver{enc: json.NewEncoder(os.Stdout)}
+}
+
+func (jo *JSONObserver) emit(v interface{}) {
+    if err := jo.enc.Encode(v) ; err != nil {
+        log.Printf("json observer: %s\n", err)
+    }
+}
+
+func (jo *JSONObserver) OnRecord(count int, rr *RecordResult) {
+    jo.emit(jsonRecordEvent{"record", count, rr.Identifier()})
+}
+
+func (jo *JSONObserver) OnError(err error) {
+    jo.emit(jsonErrorEvent{"error", err.Error()})
+}
+
+func (jo *JSONObserver) OnDirRotated(dirId int, dir string) {
+    jo.emit(jsonDirRotatedEvent{"dir_rotated", dirId, dir})
+}
+
+func (jo *JSONObserver) OnDirCompressed(dir string, archive string) {
+    jo.emit(jsonDirCompressedEvent{"dir_compressed", dir, archive})
+}
+
+func (jo *JSONObserver) OnProgress(count int, rate float64, eta time.Duration) {
+    jo.emit(jsonProgressEvent{"progress", count, rate, eta.Seconds()})
+}
+
+func (jo *JSONObserver) OnCompleted(harvested int, skipped int, filtered int, errors int) {
+    jo.emit(jsonSummaryEvent{"summary", harvested, skipped, filtered, errors})
+}
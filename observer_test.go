@@ -0,0 +1,71 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "testing"
+    "time"
+)
+
+func newTestJSONObserver() (*JSONObserver, *bytes.Buffer) {
+    buf := &bytes.Buffer{}
+    return &JSONObserver{enc: json.NewEncoder(buf)}, buf
+}
+
+func decodeEvent(t *testing.T, buf *bytes.Buffer) map[string]interface{} {
+    t.Helper()
+
+    var event map[string]interface{}
+    if err := json.Unmarshal(buf.Bytes(), &event) ; err != nil {
+        t.Fatalf("decoding emitted JSON: %v\n%s", err, buf.String())
+    }
+    return event
+}
+
+// Guards the JSON event stream's shape: other processes parse this output line by line, so
+// every event must carry an "event" discriminator plus the fields its consumers rely on.
+func TestJSONObserverEventShapes(t *testing.T) {
+    jo, buf := newTestJSONObserver()
+    jo.OnRecord(3, &RecordResult{})
+    event := decodeEvent(t, buf)
+    if (event["event"] != "record") || (event["count"] != float64(3)) {
+        t.Errorf("OnRecord: got %v", event)
+    }
+
+    buf.Reset()
+    jo.OnError(errors.New("boom"))
+    event = decodeEvent(t, buf)
+    if (event["event"] != "error") || (event["message"] != "boom") {
+        t.Errorf("OnError: got %v", event)
+    }
+
+    buf.Reset()
+    jo.OnDirRotated(2, "20260730T000000/02")
+    event = decodeEvent(t, buf)
+    if (event["event"] != "dir_rotated") || (event["dir_id"] != float64(2)) || (event["dir"] != "20260730T000000/02") {
+        t.Errorf("OnDirRotated: got %v", event)
+    }
+
+    buf.Reset()
+    jo.OnDirCompressed("20260730T000000/02", "20260730T000000/02.zip")
+    event = decodeEvent(t, buf)
+    if (event["event"] != "dir_compressed") || (event["archive"] != "20260730T000000/02.zip") {
+        t.Errorf("OnDirCompressed: got %v", event)
+    }
+
+    buf.Reset()
+    jo.OnProgress(10, 2.5, 4*time.Second)
+    event = decodeEvent(t, buf)
+    if (event["event"] != "progress") || (event["rate_per_sec"] != 2.5) || (event["eta_seconds"] != float64(4)) {
+        t.Errorf("OnProgress: got %v", event)
+    }
+
+    buf.Reset()
+    jo.OnCompleted(5, 1, 2, 0)
+    event = decodeEvent(t, buf)
+    if (event["event"] != "summary") || (event["harvested"] != float64(5)) || (event["skipped"] != float64(1)) ||
+        (event["filtered"] != float64(2)) || (event["errors"] != float64(0)) {
+        t.Errorf("OnCompleted: got %v", event)
+    }
+}
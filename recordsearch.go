@@ -11,6 +11,7 @@ import (
     "strings"
     "bytes"
     "fmt"
+    "regexp"
 
     "launchpad.net/xmlpath"
 )
@@ -74,6 +75,29 @@ func (b RSBool) String() string {
     }
 }
 
+
+// A numeric value
+type RSNumber       float64
+
+func (n RSNumber) Bool() bool {
+    return (float64(n) != 0)
+}
+
+func (n RSNumber) String() string {
+    return strconv.FormatFloat(float64(n), 'g', -1, 64)
+}
+
+// Attempts to coerce a value to a number.  Returns false if the value is not a number and
+// cannot be parsed as one.
+func asRSNumber(val RSExprValue) (float64, bool) {
+    if n, isNumber := val.(RSNumber); isNumber {
+        return float64(n), true
+    }
+
+    f, err := strconv.ParseFloat(val.String(), 64)
+    return f, (err == nil)
+}
+
 // Native function types
 type RSNativeFunction   func(rr *RecordResult, args []RSExprValue) (RSExprValue, error)
 
@@ -122,6 +146,147 @@ func (lt RSExprLiteral) Evaluate(rr *RecordResult) (RSExprValue, error) {
     return lt.val, nil
 }
 
+
+// A binary operator: "&&", "||", "==", "!=", "<", "<=", ">", ">=" and "=~".
+//
+// "&&" and "||" are short-circuited: the right-hand side is only evaluated if the result
+// cannot already be determined from the left-hand side.
+//
+type RSExprBinary struct {
+    Op          string
+    Left        RSExprAst
+    Right       RSExprAst
+}
+
+func (bin *RSExprBinary) Evaluate(rr *RecordResult) (RSExprValue, error) {
+    left, err := bin.Left.Evaluate(rr)
+    if err != nil {
+        return nil, err
+    }
+
+    switch bin.Op {
+    case "&&":
+        if !left.Bool() {
+            return RSBool(false), nil
+        }
+        right, err := bin.Right.Evaluate(rr)
+        if err != nil {
+            return nil, err
+        }
+        return RSBool(right.Bool()), nil
+
+    case "||":
+        if left.Bool() {
+            return RSBool(true), nil
+        }
+        right, err := bin.Right.Evaluate(rr)
+        if err != nil {
+            return nil, err
+        }
+        return RSBool(right.Bool()), nil
+    }
+
+    right, err := bin.Right.Evaluate(rr)
+    if err != nil {
+        return nil, err
+    }
+
+    return evalComparison(bin.Op, left, right)
+}
+
+// Evaluates a comparison operator against two already-evaluated values.  Numeric comparisons
+// are used when both sides can be coerced to a number; otherwise the values are compared as
+// strings.
+func evalComparison(op string, left RSExprValue, right RSExprValue) (RSExprValue, error) {
+    switch op {
+    case "==":
+        return RSBool(rsValuesEqual(left, right)), nil
+
+    case "!=":
+        return RSBool(!rsValuesEqual(left, right)), nil
+
+    case "=~":
+        re, err := regexp.Compile(right.String())
+        if err != nil {
+            return nil, err
+        }
+        return RSBool(re.MatchString(left.String())), nil
+
+    case "<", "<=", ">", ">=":
+        if ln, lok := asRSNumber(left); lok {
+            if rn, rok := asRSNumber(right); rok {
+                return RSBool(compareFloats(op, ln, rn)), nil
+            }
+        }
+        return RSBool(compareStrings(op, left.String(), right.String())), nil
+    }
+
+    return nil, fmt.Errorf("unknown operator: %s", op)
+}
+
+func rsValuesEqual(left RSExprValue, right RSExprValue) bool {
+    if ln, lok := asRSNumber(left); lok {
+        if rn, rok := asRSNumber(right); rok {
+            return (ln == rn)
+        }
+    }
+    return (left.String() == right.String())
+}
+
+func compareFloats(op string, left float64, right float64) bool {
+    switch op {
+    case "<":
+        return left < right
+    case "<=":
+        return left <= right
+    case ">":
+        return left > right
+    default:
+        return left >= right
+    }
+}
+
+func compareStrings(op string, left string, right string) bool {
+    switch op {
+    case "<":
+        return left < right
+    case "<=":
+        return left <= right
+    case ">":
+        return left > right
+    default:
+        return left >= right
+    }
+}
+
+
+// A unary operator: "!" or "-".
+//
+type RSExprUnary struct {
+    Op          string
+    Operand     RSExprAst
+}
+
+func (un *RSExprUnary) Evaluate(rr *RecordResult) (RSExprValue, error) {
+    val, err := un.Operand.Evaluate(rr)
+    if err != nil {
+        return nil, err
+    }
+
+    switch un.Op {
+    case "!":
+        return RSBool(!val.Bool()), nil
+    case "-":
+        n, ok := asRSNumber(val)
+        if !ok {
+            return nil, fmt.Errorf("operand of unary '-' is not a number: %s", val.String())
+        }
+        return RSNumber(-n), nil
+    }
+
+    return nil, fmt.Errorf("unknown unary operator: %s", un.Op)
+}
+
 // ------------------------------------------------------------------------------
 //
 
@@ -134,6 +299,18 @@ func (e *etoken) Error() string {
     return fmt.Sprintf("Expected %s but got %s", scanner.TokenString(e.Expected), scanner.TokenString(e.Actual))
 }
 
+// Two-character operator tokens.  These sit well outside the range of runes and special
+// tokens text/scanner hands back, so there's no risk of colliding with either.
+const (
+    tokAndAnd   rune = -1000 - iota
+    tokOrOr
+    tokEq
+    tokNeq
+    tokLte
+    tokGte
+    tokMatch
+)
+
 // Record search parser
 type recordSearchParser struct {
     scan        *scanner.Scanner
@@ -141,11 +318,50 @@ type recordSearchParser struct {
     tokText     string
 }
 
-// Gets the next token
+// Gets the next token, combining the two-character operators ("&&", "||", "==", "!=", "<=",
+// ">=" and "=~") that text/scanner would otherwise hand back as two separate runes.
 func (rsp *recordSearchParser) nextToken() {
-    if (rsp.tok != scanner.EOF) {
-        rsp.tok = rsp.scan.Scan()
-        rsp.tokText = rsp.scan.TokenText()
+    if (rsp.tok == scanner.EOF) {
+        return
+    }
+
+    rsp.tok = rsp.scan.Scan()
+    rsp.tokText = rsp.scan.TokenText()
+
+    switch rsp.tok {
+    case '&':
+        if rsp.scan.Peek() == '&' {
+            rsp.scan.Next()
+            rsp.tok, rsp.tokText = tokAndAnd, "&&"
+        }
+    case '|':
+        if rsp.scan.Peek() == '|' {
+            rsp.scan.Next()
+            rsp.tok, rsp.tokText = tokOrOr, "||"
+        }
+    case '=':
+        if rsp.scan.Peek() == '=' {
+            rsp.scan.Next()
+            rsp.tok, rsp.tokText = tokEq, "=="
+        } else if rsp.scan.Peek() == '~' {
+            rsp.scan.Next()
+            rsp.tok, rsp.tokText = tokMatch, "=~"
+        }
+    case '!':
+        if rsp.scan.Peek() == '=' {
+            rsp.scan.Next()
+            rsp.tok, rsp.tokText = tokNeq, "!="
+        }
+    case '<':
+        if rsp.scan.Peek() == '=' {
+            rsp.scan.Next()
+            rsp.tok, rsp.tokText = tokLte, "<="
+        }
+    case '>':
+        if rsp.scan.Peek() == '=' {
+            rsp.scan.Next()
+            rsp.tok, rsp.tokText = tokGte, ">="
+        }
     }
 }
 
@@ -166,19 +382,171 @@ func (rsp *recordSearchParser) consume(tok rune) (txt string, err error) {
     return
 }
 
-// Parses an expression
-//      <expr>  =   <fncall> | <atom>
+// Parses an expression.  Operators are precedence-climbed from loosest to tightest binding:
+//
+//      <expr>          =   <orExpr>
+//      <orExpr>        =   <andExpr> ( "||" <andExpr> )*
+//      <andExpr>       =   <equality> ( "&&" <equality> )*
+//      <equality>      =   <comparison> ( ("==" | "!=" | "=~") <comparison> )*
+//      <comparison>    =   <unary> ( ("<" | "<=" | ">" | ">=") <unary> )*
+//      <unary>         =   "!" <unary> | "-" <unary> | <primary>
+//      <primary>       =   <fncall> | <atom> | "(" <expr> ")"
 func (rsp *recordSearchParser) parseExpr() (RSExprAst, error) {
+    return rsp.parseOrExpr()
+}
+
+func (rsp *recordSearchParser) parseOrExpr() (RSExprAst, error) {
+    left, err := rsp.parseAndExpr()
+    for (err == nil) && (rsp.tok == tokOrOr) {
+        rsp.nextToken()
+
+        var right RSExprAst
+        right, err = rsp.parseAndExpr()
+        if err == nil {
+            left = &RSExprBinary{"||", left, right}
+        }
+    }
+    return left, err
+}
+
+func (rsp *recordSearchParser) parseAndExpr() (RSExprAst, error) {
+    left, err := rsp.parseEquality()
+    for (err == nil) && (rsp.tok == tokAndAnd) {
+        rsp.nextToken()
+
+        var right RSExprAst
+        right, err = rsp.parseEquality()
+        if err == nil {
+            left = &RSExprBinary{"&&", left, right}
+        }
+    }
+    return left, err
+}
+
+func (rsp *recordSearchParser) parseEquality() (RSExprAst, error) {
+    left, err := rsp.parseComparison()
+    for err == nil {
+        op, ok := equalityOp(rsp.tok)
+        if !ok {
+            break
+        }
+        rsp.nextToken()
+
+        var right RSExprAst
+        right, err = rsp.parseComparison()
+        if err == nil {
+            left = &RSExprBinary{op, left, right}
+        }
+    }
+    return left, err
+}
+
+func equalityOp(tok rune) (string, bool) {
+    switch tok {
+    case tokEq:
+        return "==", true
+    case tokNeq:
+        return "!=", true
+    case tokMatch:
+        return "=~", true
+    default:
+        return "", false
+    }
+}
+
+func (rsp *recordSearchParser) parseComparison() (RSExprAst, error) {
+    left, err := rsp.parseUnary()
+    for err == nil {
+        op, ok := comparisonOp(rsp.tok)
+        if !ok {
+            break
+        }
+        rsp.nextToken()
+
+        var right RSExprAst
+        right, err = rsp.parseUnary()
+        if err == nil {
+            left = &RSExprBinary{op, left, right}
+        }
+    }
+    return left, err
+}
+
+func comparisonOp(tok rune) (string, bool) {
+    switch tok {
+    case '<':
+        return "<", true
+    case '>':
+        return ">", true
+    case tokLte:
+        return "<=", true
+    case tokGte:
+        return ">=", true
+    default:
+        return "", false
+    }
+}
+
+// Parses a unary expression
+//      <unary> =   "!" <unary> | "-" <unary> | <primary>
+func (rsp *recordSearchParser) parseUnary() (RSExprAst, error) {
+    if rsp.nextTokenIs('!') {
+        rsp.nextToken()
+
+        operand, err := rsp.parseUnary()
+        if err != nil {
+            return nil, err
+        }
+        return &RSExprUnary{"!", operand}, nil
+    }
+
+    if rsp.nextTokenIs('-') {
+        rsp.nextToken()
+
+        operand, err := rsp.parseUnary()
+        if err != nil {
+            return nil, err
+        }
+        return &RSExprUnary{"-", operand}, nil
+    }
+
+    return rsp.parsePrimary()
+}
+
+// Parses a primary expression
+//      <primary>   =   <fncall> | <atom> | "(" <expr> ")"
+func (rsp *recordSearchParser) parsePrimary() (RSExprAst, error) {
+    if rsp.nextTokenIs('(') {
+        rsp.consume('(')
+
+        expr, err := rsp.parseExpr()
+        if err != nil {
+            return nil, err
+        }
+        if _, err := rsp.consume(')') ; err != nil {
+            return nil, err
+        }
+        return expr, nil
+    }
+
     if (rsp.tok == scanner.Ident) {
         return rsp.parseFn()
-    } else {
-        return rsp.parseAtom()
     }
+    return rsp.parseAtom()
 }
 
 // Parses an atom
-//      <atom>  =   STRING
+//      <atom>  =   STRING | NUMBER
 func (rsp *recordSearchParser) parseAtom() (RSExprAst, error) {
+    if (rsp.tok == scanner.Int) || (rsp.tok == scanner.Float) {
+        n, err := strconv.ParseFloat(rsp.tokText, 64)
+        if err != nil {
+            return nil, err
+        }
+        rsp.nextToken()
+        return RSExprLiteral{RSNumber(n)}, nil
+    }
+
     str, err := rsp.readString()
     return RSExprLiteral{RSString(str)}, err
 }
@@ -257,15 +625,23 @@ func ParseRSExpr(expr string) (RSExprAst, error) {
     rsp := &recordSearchParser{}
     rsp.scan = new(scanner.Scanner)
     rsp.scan.Init(strings.NewReader(expr))
-    rsp.scan.Mode = scanner.ScanIdents | scanner.ScanStrings | scanner.ScanRawStrings | scanner.SkipComments
+    rsp.scan.Mode = scanner.ScanIdents | scanner.ScanStrings | scanner.ScanRawStrings | scanner.ScanInts | scanner.ScanFloats | scanner.SkipComments
     rsp.nextToken()
 
     ast, err := rsp.parseExpr()
-    if err == nil {
-        return ast, nil
-    } else {
+    if err != nil {
         return nil, err
     }
+
+    // parseExpr returns as soon as it can't extend the current production, rather than
+    // erroring on whatever's left -- so a typo like "=" for "==" would otherwise parse as
+    // a short expression followed by silently-ignored leftover tokens instead of a parse
+    // error.
+    if rsp.tok != scanner.EOF {
+        return nil, &etoken{scanner.EOF, rsp.tok}
+    }
+
+    return ast, nil
 }
 
 // -----------------------------------------------------------------------------
@@ -0,0 +1,84 @@
+package main
+
+import "testing"
+
+func evalRSExpr(t *testing.T, expr string) RSExprValue {
+    t.Helper()
+
+    ast, err := ParseRSExpr(expr)
+    if err != nil {
+        t.Fatalf("ParseRSExpr(%q): %v", expr, err)
+    }
+
+    val, err := ast.Evaluate(&RecordResult{})
+    if err != nil {
+        t.Fatalf("Evaluate(%q): %v", expr, err)
+    }
+    return val
+}
+
+// Guards against the parseAtom gap where a bare "-" before a numeric literal failed to
+// parse at all, so a comparison against a negative bound like "-1" couldn't be written.
+func TestParseUnaryMinus(t *testing.T) {
+    tests := []struct {
+        expr string
+        want bool
+    }{
+        {"-1 < 0", true},
+        {"0 < -1", false},
+        {"-1 == -1", true},
+        {"-(-5) == 5", true},
+        {"-1 < 1", true},
+    }
+
+    for _, tt := range tests {
+        got := evalRSExpr(t, tt.expr).Bool()
+        if got != tt.want {
+            t.Errorf("%q: got %v, want %v", tt.expr, got, tt.want)
+        }
+    }
+}
+
+func TestParseUnaryMinusOnNonNumberErrors(t *testing.T) {
+    ast, err := ParseRSExpr(`-"abc"`)
+    if err != nil {
+        t.Fatalf("ParseRSExpr: %v", err)
+    }
+
+    if _, err := ast.Evaluate(&RecordResult{}) ; err == nil {
+        t.Error("expected an error negating a string, got nil")
+    }
+}
+
+// Guards against rsValuesEqual comparing "==" / "!=" only when both sides already happen to
+// be RSNumber, unlike the other comparison operators which coerce an RSString via
+// asRSNumber.  A string value that's numerically but not textually identical to a number
+// (different formatting of the same quantity) must still compare equal.
+func TestEqualityCoercesStringsToNumbers(t *testing.T) {
+    tests := []struct {
+        expr string
+        want bool
+    }{
+        {`"05" == 5`, true},
+        {`"5.0" == 5`, true},
+        {`"5" != 5`, false},
+        {`"abc" == 5`, false},
+        {`"abc" != 5`, true},
+    }
+
+    for _, tt := range tests {
+        got := evalRSExpr(t, tt.expr).Bool()
+        if got != tt.want {
+            t.Errorf("%q: got %v, want %v", tt.expr, got, tt.want)
+        }
+    }
+}
+
+// Guards against ParseRSExpr silently discarding trailing tokens it can't fit into the
+// current production -- e.g. a typo'd "=" instead of "==" -- rather than raising a parse
+// error.
+func TestParseRSExprRejectsTrailingTokens(t *testing.T) {
+    if _, err := ParseRSExpr(`urn() = "x"`) ; err == nil {
+        t.Error(`ParseRSExpr("urn() = \"x\"") succeeded, want a parse error for the leftover "="`)
+    }
+}
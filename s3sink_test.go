@@ -0,0 +1,35 @@
+package main
+
+import (
+    "net/http"
+    "testing"
+)
+
+func TestSha256Hex(t *testing.T) {
+    // Known SHA-256 of the empty string, per the AWS SigV4 worked examples.
+    want := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+    if got := sha256Hex(nil) ; got != want {
+        t.Errorf("sha256Hex(nil) = %s, want %s", got, want)
+    }
+}
+
+func TestCanonicalizeHeaders(t *testing.T) {
+    header := http.Header{}
+    header.Set("X-Amz-Date", "20260730T000000Z")
+    header.Set("X-Amz-Content-Sha256", "abc123")
+    header.Set("Content-Type", "application/xml") // not x-amz-*, must be excluded
+
+    canonical, signed := canonicalizeHeaders("example.com", header)
+
+    wantCanonical := "host:example.com\n" +
+        "x-amz-content-sha256:abc123\n" +
+        "x-amz-date:20260730T000000Z\n"
+    wantSigned := "host;x-amz-content-sha256;x-amz-date"
+
+    if canonical != wantCanonical {
+        t.Errorf("canonical headers = %q, want %q", canonical, wantCanonical)
+    }
+    if signed != wantSigned {
+        t.Errorf("signed headers = %q, want %q", signed, wantSigned)
+    }
+}
@@ -0,0 +1,176 @@
+// Record selection.  Builds up a Selector from include/exclude identifier lists, glob
+// patterns and RS-expression predicates, and uses it to decide whether a harvested record
+// should be kept.
+
+package main
+
+import (
+    "bufio"
+    "os"
+    "regexp"
+    "strings"
+)
+
+
+// A Selector decides, for each harvested record, whether it should be kept or skipped.  It
+// is built up from an optional include list, any number of exclude patterns and an optional
+// RS-expression predicate.
+type Selector struct {
+    includePatterns     []string
+    excludePatterns     []string
+    excludeAst          RSExprAst
+
+    Skipped             int
+}
+
+// Creates a Selector from the command line sources.  includeFile and excludeFile are paths
+// to newline-delimited pattern files (loaded with loadPatternFile); excludeURN is a
+// comma-separated list of glob patterns taken directly from the command line; excludeExpr is
+// an RS-expression evaluated against each record.
+//
+// An empty string disables the corresponding source.  If all four are empty, NewSelector
+// returns nil so callers can skip the Allows() check entirely.
+func NewSelector(includeFile string, excludeFile string, excludeURN string, excludeExpr string) (*Selector, error) {
+    if (includeFile == "") && (excludeFile == "") && (excludeURN == "") && (excludeExpr == "") {
+        return nil, nil
+    }
+
+    sel := &Selector{}
+
+    if includeFile != "" {
+        patterns, err := loadPatternFile(includeFile)
+        if err != nil {
+            return nil, err
+        }
+        sel.includePatterns = patterns
+    }
+
+    if excludeFile != "" {
+        patterns, err := loadPatternFile(excludeFile)
+        if err != nil {
+            return nil, err
+        }
+        sel.excludePatterns = append(sel.excludePatterns, patterns...)
+    }
+
+    if excludeURN != "" {
+        for _, pattern := range strings.Split(excludeURN, ",") {
+            if pattern = strings.TrimSpace(pattern) ; pattern != "" {
+                sel.excludePatterns = append(sel.excludePatterns, pattern)
+            }
+        }
+    }
+
+    if excludeExpr != "" {
+        ast, err := ParseRSExpr(excludeExpr)
+        if err != nil {
+            return nil, err
+        }
+        sel.excludeAst = ast
+    }
+
+    return sel, nil
+}
+
+// Allows returns true if the record should be kept.  It is checked, in order, against the
+// include list, the exclude patterns and finally the exclude expression; any list "wins" as
+// soon as it rules the record out.
+func (sel *Selector) Allows(res *RecordResult) bool {
+    if !sel.AllowsIdentifier(res.Identifier()) {
+        return false
+    }
+
+    if sel.excludeAst != nil {
+        val, err := sel.excludeAst.Evaluate(res)
+        if (err == nil) && (val.Bool()) {
+            return false
+        }
+    }
+
+    return true
+}
+
+// AllowsIdentifier is the subset of Allows that can be decided from an identifier alone --
+// the include and exclude pattern lists, but not the exclude expression, which needs the
+// full record.  This is what lets a harvester short-circuit before fetching a record at all:
+// anything AllowsIdentifier already rules out will fail Allows too, so there's no point
+// paying for the fetch just to throw the result away.
+func (sel *Selector) AllowsIdentifier(id string) bool {
+    if (len(sel.includePatterns) > 0) && !matchesAnyPattern(id, sel.includePatterns) {
+        return false
+    }
+
+    if matchesAnyPattern(id, sel.excludePatterns) {
+        return false
+    }
+
+    return true
+}
+
+func matchesAnyPattern(id string, patterns []string) bool {
+    for _, pattern := range patterns {
+        if globMatch(pattern, id) {
+            return true
+        }
+    }
+    return false
+}
+
+// Loads a newline-delimited pattern file, the way restic reads its --files-from and
+// --exclude-file lists: blank lines and lines starting with "#" are skipped, and surrounding
+// whitespace is trimmed from what's left.
+func loadPatternFile(filename string) ([]string, error) {
+    file, err := os.Open(filename)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    var patterns []string
+    scan := bufio.NewScanner(file)
+    for scan.Scan() {
+        line := strings.TrimSpace(scan.Text())
+        if (line == "") || strings.HasPrefix(line, "#") {
+            continue
+        }
+        patterns = append(patterns, line)
+    }
+
+    return patterns, scan.Err()
+}
+
+// Matches a glob pattern against a string.  "*" matches any run of characters other than
+// "/", "?" matches any single character other than "/", and "**" matches any run of
+// characters including "/".  A pattern with none of these is just a literal, exact match.
+func globMatch(pattern string, s string) bool {
+    re, err := globToRegexp(pattern)
+    if err != nil {
+        return (pattern == s)
+    }
+    return re.MatchString(s)
+}
+
+func globToRegexp(pattern string) (*regexp.Regexp, error) {
+    var buf strings.Builder
+    buf.WriteString("^")
+
+    runes := []rune(pattern)
+    for i := 0; i < len(runes); i++ {
+        switch runes[i] {
+        case '*':
+            if (i + 1 < len(runes)) && (runes[i + 1] == '*') {
+                buf.WriteString(".*")
+                i++
+            } else {
+                buf.WriteString("[^/]*")
+            }
+        case '?':
+            buf.WriteString("[^/]")
+        default:
+            buf.WriteString(regexp.QuoteMeta(string(runes[i])))
+        }
+    }
+
+    buf.WriteString("$")
+    return regexp.Compile(buf.String())
+}
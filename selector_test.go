@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+// Guards the fetch-then-discard bug: AllowsIdentifier must rule out anything the full
+// Allows check would also rule out via include/exclude patterns, since it's what lets
+// harvestGuard short-circuit before a record is ever fetched.
+func TestSelectorAllowsIdentifier(t *testing.T) {
+    sel := &Selector{
+        includePatterns: []string{"oai:repo:a*"},
+        excludePatterns: []string{"oai:repo:a2"},
+    }
+
+    tests := []struct {
+        id   string
+        want bool
+    }{
+        {"oai:repo:a1", true},
+        {"oai:repo:a2", false},
+        {"oai:repo:b1", false},
+    }
+
+    for _, tt := range tests {
+        if got := sel.AllowsIdentifier(tt.id) ; got != tt.want {
+            t.Errorf("AllowsIdentifier(%q) = %v, want %v", tt.id, got, tt.want)
+        }
+    }
+}
+
+func TestSelectorAllowsIdentifierIgnoresExcludeExpr(t *testing.T) {
+    ast, err := ParseRSExpr(`xp("//status") == "deleted"`)
+    if err != nil {
+        t.Fatalf("ParseRSExpr: %v", err)
+    }
+    sel := &Selector{excludeAst: ast}
+
+    if !sel.AllowsIdentifier("oai:repo:a1") {
+        t.Error("AllowsIdentifier should not evaluate the exclude expression")
+    }
+}
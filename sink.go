@@ -0,0 +1,66 @@
+// Harvest sinks.  A HarvestSink is where a harvest's records end up; HarvestCommand drives
+// exactly one for the life of a run, without caring whether "somewhere" is a directory tree,
+// a streaming archive, object storage, or the content-addressable store in cassink.go.
+
+package main
+
+import (
+    "fmt"
+    "strings"
+)
+
+
+// A HarvestSink receives the records of a harvest in order.  Records are grouped into
+// batches the same way the directory layout always has -- one batch per -D records -- so sinks that want to mirror that layout (an archive per batch, say) have
+// enough to work with; sinks that don't care about batches, such as the CAS store, are free
+// to ignore dirId entirely.
+type HarvestSink interface {
+    // OpenBatch starts batch dirId.  Called once before that batch's first WriteRecord.
+    OpenBatch(dirId int) error
+
+    // WriteRecord saves a single record into the current batch.
+    WriteRecord(res *RecordResult) error
+
+    // CloseBatch finishes the current batch.  If doing so produced a standalone archive --
+    // the directory sink's optional post-hoc zip, say -- archive is its path, for the
+    // caller to report as an OnDirCompressed event; otherwise archive is "".
+    CloseBatch() (archive string, err error)
+
+    // Close flushes and releases anything the sink is still holding open.  Called once,
+    // after the harvest's last CloseBatch.
+    Close() error
+
+    // SupportsResume reports whether reopening this sink against a checkpointed --resume
+    // run is safe.  Sinks that key each write by the record's URN or content digest (the
+    // directory layout, the CAS store, S3) can be reopened as-is; sinks that own a single archive file
+    // opened fresh every run (tar, targz, zip) would truncate whatever that archive already
+    // held, silently losing the in-progress batch.
+    SupportsResume() bool
+}
+
+// NewSink builds the HarvestSink selected by the --store flag.  store is one of:
+//
+//      ""                      the per-URN directory layout, rooted at dirPrefix
+//      "cas:<dir>"             the content-addressable store (see cassink.go)
+//      "tar:<dir>"             one uncompressed tar archive per batch, under dir
+//      "targz:<dir>"           the same, gzip-compressed
+//      "zip:<file>"            a single append-only zip covering the whole run
+//      "s3:<bucket>/<prefix>"  objects in an S3-compatible bucket (see s3sink.go)
+func NewSink(store string, dirPrefix string, filenameFilterAst RSExprAst, compressDirs bool) (HarvestSink, error) {
+    switch {
+    case store == "":
+        return NewDirSink(dirPrefix, filenameFilterAst, compressDirs), nil
+    case strings.HasPrefix(store, "cas:"):
+        return NewCASHarvestSink(strings.TrimPrefix(store, "cas:"))
+    case strings.HasPrefix(store, "targz:"):
+        return NewTarSink(strings.TrimPrefix(store, "targz:"), true), nil
+    case strings.HasPrefix(store, "tar:"):
+        return NewTarSink(strings.TrimPrefix(store, "tar:"), false), nil
+    case strings.HasPrefix(store, "zip:"):
+        return NewZipSink(strings.TrimPrefix(store, "zip:"))
+    case strings.HasPrefix(store, "s3:"):
+        return NewS3Sink(strings.TrimPrefix(store, "s3:"))
+    default:
+        return nil, fmt.Errorf("unrecognised --store value %q", store)
+    }
+}
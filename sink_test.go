@@ -0,0 +1,34 @@
+package main
+
+import (
+    "path/filepath"
+    "testing"
+)
+
+// Guards the truncate-on-resume bug: sinks that own a single archive file opened fresh
+// every run must say so, so Run() can refuse --resume rather than silently truncating it.
+func TestSinkSupportsResume(t *testing.T) {
+    tests := []struct {
+        name string
+        sink HarvestSink
+        want bool
+    }{
+        {"dir", NewDirSink(t.TempDir(), nil, false), true},
+        {"tar", NewTarSink(t.TempDir(), false), false},
+        {"targz", NewTarSink(t.TempDir(), true), false},
+    }
+
+    for _, tt := range tests {
+        if got := tt.sink.SupportsResume() ; got != tt.want {
+            t.Errorf("%s: SupportsResume() = %v, want %v", tt.name, got, tt.want)
+        }
+    }
+
+    zipSink, err := NewZipSink(filepath.Join(t.TempDir(), "out.zip"))
+    if err != nil {
+        t.Fatalf("NewZipSink: %v", err)
+    }
+    if zipSink.SupportsResume() {
+        t.Errorf("zip: SupportsResume() = true, want false")
+    }
+}
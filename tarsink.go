@@ -0,0 +1,100 @@
+// A harvest sink that streams each batch straight into a tar archive instead of a directory
+// of loose files, optionally gzip-compressed.  Selected on the command line with
+// "--store tar:<dir>" or "--store targz:<dir>".
+
+package main
+
+import (
+    "archive/tar"
+    "compress/gzip"
+    "fmt"
+    "io"
+    "os"
+    "time"
+)
+
+
+type TarSink struct {
+    dirPrefix   string
+    gzipped     bool
+
+    curDirId    int
+    file        *os.File
+    gzw         *gzip.Writer
+    tw          *tar.Writer
+}
+
+func NewTarSink(dirPrefix string, gzipped bool) *TarSink {
+    return &TarSink{dirPrefix: dirPrefix, gzipped: gzipped}
+}
+
+func (sink *TarSink) archiveName(dirId int) string {
+    if sink.gzipped {
+        return fmt.Sprintf("%s/%02d.tar.gz", sink.dirPrefix, dirId)
+    }
+    return fmt.Sprintf("%s/%02d.tar", sink.dirPrefix, dirId)
+}
+
+func (sink *TarSink) OpenBatch(dirId int) error {
+    if err := os.MkdirAll(sink.dirPrefix, 0755) ; err != nil {
+        return err
+    }
+
+    file, err := os.Create(sink.archiveName(dirId))
+    if err != nil {
+        return err
+    }
+
+    sink.curDirId = dirId
+    sink.file = file
+
+    var w io.Writer = file
+    if sink.gzipped {
+        sink.gzw = gzip.NewWriter(file)
+        w = sink.gzw
+    }
+    sink.tw = tar.NewWriter(w)
+
+    return nil
+}
+
+func (sink *TarSink) WriteRecord(res *RecordResult) error {
+    name := EscapeIdForFilename(res.Identifier()) + ".xml"
+    content := []byte(res.Content)
+
+    if err := sink.tw.WriteHeader(&tar.Header{
+        Name:       name,
+        Mode:       0644,
+        Size:       int64(len(content)),
+        ModTime:    time.Now(),
+    }) ; err != nil {
+        return err
+    }
+
+    _, err := sink.tw.Write(content)
+    return err
+}
+
+func (sink *TarSink) CloseBatch() (string, error) {
+    if err := sink.tw.Close() ; err != nil {
+        return "", err
+    }
+    if sink.gzw != nil {
+        if err := sink.gzw.Close() ; err != nil {
+            return "", err
+        }
+        sink.gzw = nil
+    }
+    return "", sink.file.Close()
+}
+
+func (sink *TarSink) Close() error {
+    return nil
+}
+
+// SupportsResume is false: OpenBatch always os.Create's the batch's archive, which
+// truncates whatever a prior run had already written to it.  A resumed harvest must not
+// reopen a tar/targz store against an in-progress batch.
+func (sink *TarSink) SupportsResume() bool {
+    return false
+}
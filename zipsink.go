@@ -0,0 +1,70 @@
+// A harvest sink that writes every record into a single append-only zip archive, rather
+// than batching into directories or per-batch archives.  Selected on the command line with
+// "--store zip:<file>".
+
+package main
+
+import (
+    "archive/zip"
+    "fmt"
+    "os"
+)
+
+
+type ZipSink struct {
+    path        string
+    file        *os.File
+    zw          *zip.Writer
+
+    curDirId    int
+}
+
+func NewZipSink(path string) (*ZipSink, error) {
+    file, err := os.Create(path)
+    if err != nil {
+        return nil, err
+    }
+
+    return &ZipSink{path: path, file: file, zw: zip.NewWriter(file)}, nil
+}
+
+// OpenBatch just records which batch subsequent records belong to; the archive itself stays
+// open across every batch of the run.
+func (sink *ZipSink) OpenBatch(dirId int) error {
+    sink.curDirId = dirId
+    return nil
+}
+
+func (sink *ZipSink) WriteRecord(res *RecordResult) error {
+    name := fmt.Sprintf("%02d/%s.xml", sink.curDirId, EscapeIdForFilename(res.Identifier()))
+
+    w, err := sink.zw.Create(name)
+    if err != nil {
+        return err
+    }
+
+    _, err = w.Write([]byte(res.Content))
+    return err
+}
+
+// CloseBatch is a no-op: there's nothing batch-scoped to finish, since every batch shares
+// the one archive.
+func (sink *ZipSink) CloseBatch() (string, error) {
+    return "", nil
+}
+
+// Close writes the zip's central directory and closes the underlying file.  Must be called
+// exactly once, after the harvest's last record.
+func (sink *ZipSink) Close() error {
+    if err := sink.zw.Close() ; err != nil {
+        return err
+    }
+    return sink.file.Close()
+}
+
+// SupportsResume is false: NewZipSink always os.Create's the archive, which truncates
+// whatever a prior run had already written to it.  A resumed harvest must not reopen a
+// zip store against an in-progress run.
+func (sink *ZipSink) SupportsResume() bool {
+    return false
+}